@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ChosenRenewalTimeAnnotation records the deterministic renewal time picked
+// from within an ARI suggested window, so that re-running the scan against
+// the same window always yields the same decision.
+const ChosenRenewalTimeAnnotation = "k8s-letsencrypt-force-renew/ari-renewal-time"
+
+// ariWindow mirrors the RFC 9773 renewalInfo response body.
+type ariWindow struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	ExplanationURL string `json:"explanationURL,omitempty"`
+}
+
+type acmeDirectory struct {
+	RenewalInfo string `json:"renewalInfo,omitempty"`
+}
+
+// ariClient discovers issuer ACME directories and queries their renewalInfo
+// endpoint, caching the directory document per issuer so it's only fetched
+// once for the lifetime of the ariClient; cached entries are never
+// refreshed.
+type ariClient struct {
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	directories map[string]*acmeDirectory
+}
+
+func newARIClient() *ariClient {
+	return &ariClient{
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		directories: make(map[string]*acmeDirectory),
+	}
+}
+
+// directoryFor returns the ACME directory for issuerURL, fetching and
+// caching it on first use.
+func (a *ariClient) directoryFor(ctx context.Context, issuerURL string) (*acmeDirectory, error) {
+	a.mu.Lock()
+	if dir, ok := a.directories[issuerURL]; ok {
+		a.mu.Unlock()
+		return dir, nil
+	}
+	a.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching ACME directory %q: %w", issuerURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching ACME directory %q", resp.StatusCode, issuerURL)
+	}
+
+	var dir acmeDirectory
+	if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil {
+		return nil, fmt.Errorf("error decoding ACME directory %q: %w", issuerURL, err)
+	}
+
+	a.mu.Lock()
+	a.directories[issuerURL] = &dir
+	a.mu.Unlock()
+	return &dir, nil
+}
+
+// suggestedWindow fetches the ARI suggested renewal window for cert,
+// returning (nil, nil) when the issuer's directory has no renewalInfo
+// endpoint, per the fallback behaviour required by RFC 9773 clients.
+func (a *ariClient) suggestedWindow(ctx context.Context, issuerURL string, cert *x509.Certificate) (*ariWindow, error) {
+	dir, err := a.directoryFor(ctx, issuerURL)
+	if err != nil {
+		return nil, err
+	}
+	if dir.RenewalInfo == "" {
+		return nil, nil
+	}
+
+	certID, err := ariCertID(cert)
+	if err != nil {
+		return nil, fmt.Errorf("error computing ARI CertID: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/%s", strings.TrimSuffix(dir.RenewalInfo, "/"), certID)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := a.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching renewalInfo for %s: %w", certID, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			if attempt >= 3 {
+				return nil, fmt.Errorf("giving up on renewalInfo for %s after %d attempts", certID, attempt+1)
+			}
+			log.Printf("renewalInfo for %s returned %d, retrying in %s", certID, resp.StatusCode, retryAfter)
+			select {
+			case <-time.After(retryAfter):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d fetching renewalInfo for %s", resp.StatusCode, certID)
+		}
+
+		var w ariWindow
+		if err := json.NewDecoder(resp.Body).Decode(&w); err != nil {
+			return nil, fmt.Errorf("error decoding renewalInfo for %s: %w", certID, err)
+		}
+		return &w, nil
+	}
+}
+
+// parseRetryAfter parses a Retry-After header value (seconds), defaulting
+// to a sensible backoff when absent or malformed.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 30 * time.Second
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// ariCertID computes the RFC 9773 CertID for cert:
+// base64url(SHA-256(AKI)) || "." || base64url(serial number bytes).
+func ariCertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("certificate has no Authority Key Identifier extension")
+	}
+	akiHash := sha256.Sum256(cert.AuthorityKeyId)
+	serialBytes := serialNumberBytes(cert.SerialNumber)
+
+	return fmt.Sprintf("%s.%s",
+		base64.RawURLEncoding.EncodeToString(akiHash[:]),
+		base64.RawURLEncoding.EncodeToString(serialBytes),
+	), nil
+}
+
+// serialNumberBytes renders a certificate serial number as the minimal
+// big-endian two's-complement byte encoding used in the DER INTEGER, which
+// is what ARI CertIDs are computed over.
+func serialNumberBytes(serial *big.Int) []byte {
+	b := serial.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		// Preserve the leading 0x00 pad byte DER uses to keep the
+		// INTEGER non-negative.
+		padded := make([]byte, len(b)+1)
+		copy(padded[1:], b)
+		return padded
+	}
+	return b
+}
+
+// pickRenewalTime deterministically chooses a time uniformly within
+// [window.Start, window.End), seeded from certID so repeated scans of the
+// same certificate/window always pick the same instant.
+func pickRenewalTime(certID string, window ariWindow) time.Time {
+	span := window.SuggestedWindow.End.Sub(window.SuggestedWindow.Start)
+	if span <= 0 {
+		return window.SuggestedWindow.Start
+	}
+	seed := int64(0)
+	for _, b := range sha256.Sum256([]byte(certID)) {
+		seed = seed<<8 | int64(b)
+	}
+	r := rand.New(rand.NewSource(seed))
+	offset := time.Duration(r.Int63n(int64(span)))
+	return window.SuggestedWindow.Start.Add(offset)
+}
+
+// ariDueForRenewal decides, using ARI, whether cert is due a refresh right
+// now. It persists the chosen renewal time on the Certificate's Secret so
+// re-runs are stable, and reports ok=false when ARI isn't available for
+// this issuer (the caller should fall back to its existing selection
+// logic in that case).
+func ariDueForRenewal(ctx context.Context, cl client.Client, a *ariClient, crt Certificate, secret core.Secret, issuerURL string, x509Cert *x509.Certificate) (due bool, ok bool, err error) {
+	certID, err := ariCertID(x509Cert)
+	if err != nil {
+		return false, false, nil
+	}
+
+	window, err := a.suggestedWindow(ctx, issuerURL, x509Cert)
+	if err != nil {
+		return false, false, err
+	}
+	if window == nil {
+		return false, false, nil
+	}
+
+	chosen, err := chosenRenewalTime(ctx, cl, secret, certID, *window)
+	if err != nil {
+		return false, false, err
+	}
+
+	return !time.Now().Before(chosen), true, nil
+}
+
+// chosenRenewalTime returns the persisted renewal time for this ARI
+// CertID/window if present, otherwise picks one and persists it.
+func chosenRenewalTime(ctx context.Context, cl client.Client, secret core.Secret, certID string, window ariWindow) (time.Time, error) {
+	key := ChosenRenewalTimeAnnotation
+	if existing, ok := secret.Annotations[key]; ok {
+		parts := strings.SplitN(existing, "|", 2)
+		if len(parts) == 2 && parts[0] == certID {
+			// Only reuse the persisted time while it still lies within the
+			// current suggested window; a moved window gets a fresh pick.
+			t, err := time.Parse(time.RFC3339, parts[1])
+			if err == nil && !t.Before(window.SuggestedWindow.Start.Truncate(time.Second)) && !t.After(window.SuggestedWindow.End) {
+				return t, nil
+			}
+		}
+	}
+
+	chosen := pickRenewalTime(certID, window)
+
+	var fresh core.Secret
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: secret.Namespace, Name: secret.Name}, &fresh); err != nil {
+		return time.Time{}, fmt.Errorf("error fetching Secret before persisting ARI renewal time: %w", err)
+	}
+	if fresh.Annotations == nil {
+		fresh.Annotations = make(map[string]string)
+	}
+	fresh.Annotations[key] = certID + "|" + chosen.Format(time.RFC3339)
+	if err := cl.Update(ctx, &fresh); err != nil {
+		return time.Time{}, fmt.Errorf("error persisting ARI renewal time on Secret: %w", err)
+	}
+
+	return chosen, nil
+}
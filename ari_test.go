@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestAriCertID(t *testing.T) {
+	t.Run("no AKI is an error", func(t *testing.T) {
+		cert := &x509.Certificate{SerialNumber: big.NewInt(1)}
+		if _, err := ariCertID(cert); err == nil {
+			t.Fatal("expected an error for a certificate with no Authority Key Identifier")
+		}
+	})
+
+	t.Run("same inputs produce the same CertID", func(t *testing.T) {
+		cert := &x509.Certificate{
+			AuthorityKeyId: []byte{0x01, 0x02, 0x03},
+			SerialNumber:   big.NewInt(12345),
+		}
+		id1, err := ariCertID(cert)
+		if err != nil {
+			t.Fatalf("ariCertID() error = %v", err)
+		}
+		id2, err := ariCertID(cert)
+		if err != nil {
+			t.Fatalf("ariCertID() error = %v", err)
+		}
+		if id1 != id2 {
+			t.Errorf("ariCertID() is not deterministic: %q != %q", id1, id2)
+		}
+	})
+
+	t.Run("different serials produce different CertIDs", func(t *testing.T) {
+		aki := []byte{0x01, 0x02, 0x03}
+		id1, err := ariCertID(&x509.Certificate{AuthorityKeyId: aki, SerialNumber: big.NewInt(1)})
+		if err != nil {
+			t.Fatalf("ariCertID() error = %v", err)
+		}
+		id2, err := ariCertID(&x509.Certificate{AuthorityKeyId: aki, SerialNumber: big.NewInt(2)})
+		if err != nil {
+			t.Fatalf("ariCertID() error = %v", err)
+		}
+		if id1 == id2 {
+			t.Errorf("ariCertID() returned the same CertID for different serial numbers: %q", id1)
+		}
+	})
+}
+
+func TestPickRenewalTime(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	var window ariWindow
+	window.SuggestedWindow.Start = start
+	window.SuggestedWindow.End = end
+
+	t.Run("picks a time within the window", func(t *testing.T) {
+		got := pickRenewalTime("cert-id-1", window)
+		if got.Before(start) || !got.Before(end) {
+			t.Errorf("pickRenewalTime() = %v, want within [%v, %v)", got, start, end)
+		}
+	})
+
+	t.Run("same CertID and window is deterministic", func(t *testing.T) {
+		got1 := pickRenewalTime("cert-id-1", window)
+		got2 := pickRenewalTime("cert-id-1", window)
+		if !got1.Equal(got2) {
+			t.Errorf("pickRenewalTime() is not deterministic: %v != %v", got1, got2)
+		}
+	})
+
+	t.Run("zero-length window returns the start", func(t *testing.T) {
+		var zero ariWindow
+		zero.SuggestedWindow.Start = start
+		zero.SuggestedWindow.End = start
+		got := pickRenewalTime("cert-id-1", zero)
+		if !got.Equal(start) {
+			t.Errorf("pickRenewalTime() = %v, want %v", got, start)
+		}
+	})
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapiv1acme "github.com/jetstack/cert-manager/pkg/apis/acme/v1"
+	cmapiv1alpha2acme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha2"
+	cmapiv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmapiv1alpha2 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const certManagerGroup = "cert-manager.io"
+
+// Certificate is a version-agnostic view of a cert-manager Certificate
+// resource, as returned by a CertClient.
+type Certificate struct {
+	Namespace     string
+	Name          string
+	UID           types.UID
+	SecretName    string
+	IssuerRefName string
+	IssuerRefKind string
+	DNSNames      []string
+	Annotations   map[string]string
+}
+
+// CertificateRequest is a version-agnostic view of a cert-manager
+// CertificateRequest resource, as returned by a CertClient.
+type CertificateRequest struct {
+	Namespace string
+	Name      string
+	OwnerUID  types.UID
+	// IssuanceDone is true once status.certificate has been populated,
+	// i.e. the request is no longer in progress.
+	IssuanceDone bool
+}
+
+// CertClient abstracts over the cert-manager API version served by the
+// cluster, so the rest of this tool doesn't need to know whether it's
+// talking to the legacy v1alpha2 API or the current cert-manager.io/v1 API.
+type CertClient interface {
+	ListCertificates(ctx context.Context) ([]Certificate, error)
+	ListCertificateRequests(ctx context.Context, namespace string) ([]CertificateRequest, error)
+	DeleteCertificateRequest(ctx context.Context, namespace, name string) error
+	// TriggerRenewal asks cert-manager to renew cert, using whichever
+	// mechanism is supported by this API version.
+	TriggerRenewal(ctx context.Context, cert Certificate) error
+	// IssuerACMEServer resolves the ACME server URL of the Issuer or
+	// ClusterIssuer backing cert.
+	IssuerACMEServer(ctx context.Context, cert Certificate) (string, error)
+	// ResolveSolverKind resolves which ACME challenge type the Issuer or
+	// ClusterIssuer backing cert is configured to use to validate dnsName,
+	// mirroring cert-manager's own solver selection: an exact dnsNames
+	// match wins, then the most specific dnsZones match, then a
+	// selector-less default solver.
+	ResolveSolverKind(ctx context.Context, cert Certificate, dnsName string) (solverKind, error)
+	// NewCertificateObject returns an empty Certificate object of the
+	// concrete type served by this API version, for use with a raw
+	// client.Client (watches, annotation reads/patches, event recording).
+	NewCertificateObject() client.Object
+}
+
+// NewCertClient discovers which cert-manager API version is served by the
+// cluster - preferring the current cert-manager.io/v1 API and falling back
+// to the legacy v1alpha2 API used by cert-manager <v1.0 - and returns the
+// matching CertClient implementation.
+func NewCertClient(cfg *rest.Config, cl client.Client) (CertClient, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error building discovery client: %w", err)
+	}
+
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("error listing served API groups: %w", err)
+	}
+
+	for _, g := range groups.Groups {
+		if g.Name != certManagerGroup {
+			continue
+		}
+		for _, v := range g.Versions {
+			if v.Version == "v1" {
+				log.Printf("Detected cert-manager.io/v1 is served by this cluster, using the v1 API")
+				return &v1CertClient{Client: cl}, nil
+			}
+		}
+	}
+
+	log.Printf("cert-manager.io/v1 is not served by this cluster, falling back to the legacy v1alpha2 API")
+	return &v1alpha2CertClient{Client: cl}, nil
+}
+
+// controllerOwnerUID returns the UID of the owner reference marked as the
+// controller, or the zero UID if owners has none.
+func controllerOwnerUID(owners []metav1.OwnerReference) types.UID {
+	for _, o := range owners {
+		if o.Controller != nil && *o.Controller {
+			return o.UID
+		}
+	}
+	return ""
+}
+
+// solverKind identifies which ACME challenge type validates a DNS name.
+type solverKind string
+
+const (
+	solverDNS01  solverKind = "dns01"
+	solverHTTP01 solverKind = "http01"
+	// solverNone is returned when no solver in an Issuer/ClusterIssuer's
+	// spec.acme.solvers list matches a given DNS name.
+	solverNone solverKind = ""
+)
+
+// acmeSolver is a version-agnostic view of a single entry in an
+// Issuer/ClusterIssuer's spec.acme.solvers list.
+type acmeSolver struct {
+	dnsNames []string
+	dnsZones []string
+	dns01    bool
+	http01   bool
+}
+
+// selectSolverKind picks the solver cert-manager would use to validate
+// dnsName out of solvers and reports its challenge type, mirroring
+// cert-manager's own precedence: an exact dnsNames match beats the most
+// specific dnsZones match, which beats a selector-less default solver.
+func selectSolverKind(solvers []acmeSolver, dnsName string) solverKind {
+	var exact, zoneMatch, fallback *acmeSolver
+	zoneMatchLen := -1
+	for i := range solvers {
+		s := &solvers[i]
+		switch {
+		case containsFold(s.dnsNames, dnsName):
+			exact = s
+		case len(s.dnsZones) > 0:
+			for _, zone := range s.dnsZones {
+				if dnsName == zone || strings.HasSuffix(dnsName, "."+zone) {
+					if len(zone) > zoneMatchLen {
+						zoneMatch = s
+						zoneMatchLen = len(zone)
+					}
+				}
+			}
+		case len(s.dnsNames) == 0 && len(s.dnsZones) == 0:
+			fallback = s
+		}
+	}
+
+	chosen := exact
+	if chosen == nil {
+		chosen = zoneMatch
+	}
+	if chosen == nil {
+		chosen = fallback
+	}
+	switch {
+	case chosen == nil:
+		return solverNone
+	case chosen.dns01:
+		return solverDNS01
+	case chosen.http01:
+		return solverHTTP01
+	default:
+		return solverNone
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// acmeIssuerSpec is the version-agnostic view of an Issuer/ClusterIssuer's
+// spec.acme block that IssuerACMEServer and ResolveSolverKind need.
+type acmeIssuerSpec struct {
+	server  string
+	solvers []acmeSolver
+}
+
+// acmeIssuerSpecFunc resolves cert's Issuer or ClusterIssuer and returns its
+// ACME spec. Each CertClient implementation supplies one of these (the only
+// part that differs between API versions is the Get call and conversion of
+// its typed solver list), and shares issuerACMEServer/resolveSolverKind
+// below instead of duplicating their logic.
+type acmeIssuerSpecFunc func(ctx context.Context, cert Certificate) (acmeIssuerSpec, error)
+
+func issuerACMEServer(ctx context.Context, cert Certificate, fetch acmeIssuerSpecFunc) (string, error) {
+	spec, err := fetch(ctx, cert)
+	if err != nil {
+		return "", err
+	}
+	return spec.server, nil
+}
+
+func resolveSolverKind(ctx context.Context, cert Certificate, dnsName string, fetch acmeIssuerSpecFunc) (solverKind, error) {
+	spec, err := fetch(ctx, cert)
+	if err != nil {
+		return solverNone, err
+	}
+	return selectSolverKind(spec.solvers, dnsName), nil
+}
+
+// v1alpha2CertClient talks to the legacy certmanager.k8s.io/v1alpha2 API
+// shipped with cert-manager <v1.0.
+type v1alpha2CertClient struct {
+	Client client.Client
+}
+
+func (c *v1alpha2CertClient) ListCertificates(ctx context.Context) ([]Certificate, error) {
+	var list cmapiv1alpha2.CertificateList
+	if err := c.Client.List(ctx, &list); err != nil {
+		return nil, fmt.Errorf("error listing v1alpha2 Certificate resources: %w", err)
+	}
+	out := make([]Certificate, 0, len(list.Items))
+	for _, crt := range list.Items {
+		out = append(out, Certificate{
+			Namespace:     crt.Namespace,
+			Name:          crt.Name,
+			UID:           crt.UID,
+			SecretName:    crt.Spec.SecretName,
+			IssuerRefName: crt.Spec.IssuerRef.Name,
+			IssuerRefKind: crt.Spec.IssuerRef.Kind,
+			DNSNames:      crt.Spec.DNSNames,
+			Annotations:   crt.Annotations,
+		})
+	}
+	return out, nil
+}
+
+func (c *v1alpha2CertClient) ListCertificateRequests(ctx context.Context, namespace string) ([]CertificateRequest, error) {
+	var list cmapiv1alpha2.CertificateRequestList
+	if err := c.Client.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing v1alpha2 CertificateRequest resources: %w", err)
+	}
+	out := make([]CertificateRequest, 0, len(list.Items))
+	for _, req := range list.Items {
+		out = append(out, CertificateRequest{
+			Namespace:    req.Namespace,
+			Name:         req.Name,
+			OwnerUID:     controllerOwnerUID(req.OwnerReferences),
+			IssuanceDone: len(req.Status.Certificate) > 0,
+		})
+	}
+	return out, nil
+}
+
+func (c *v1alpha2CertClient) DeleteCertificateRequest(ctx context.Context, namespace, name string) error {
+	req := &cmapiv1alpha2.CertificateRequest{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	return c.Client.Delete(ctx, req)
+}
+
+// TriggerRenewal manually overrides/sets the IssuerNameAnnotationKey on the
+// Certificate's Secret. This causes cert-manager to assume the Certificate's
+// issuerRef has changed and trigger a one-time renewal - the only mechanism
+// available pre-v1.0, since the v1alpha2 API has no Issuing condition.
+func (c *v1alpha2CertClient) TriggerRenewal(ctx context.Context, cert Certificate) error {
+	var secret core.Secret
+	if err := c.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.SecretName}, &secret); err != nil {
+		return fmt.Errorf("error fetching Secret for Certificate: %w", err)
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[cmapiv1alpha2.IssuerNameAnnotationKey] = "force-renewal-triggered"
+	if err := c.Client.Update(ctx, &secret); err != nil {
+		return fmt.Errorf("error updating Secret for Certificate: %w", err)
+	}
+	return nil
+}
+
+func (c *v1alpha2CertClient) IssuerACMEServer(ctx context.Context, cert Certificate) (string, error) {
+	return issuerACMEServer(ctx, cert, c.acmeIssuerSpec)
+}
+
+func (c *v1alpha2CertClient) ResolveSolverKind(ctx context.Context, cert Certificate, dnsName string) (solverKind, error) {
+	return resolveSolverKind(ctx, cert, dnsName, c.acmeIssuerSpec)
+}
+
+func (c *v1alpha2CertClient) acmeIssuerSpec(ctx context.Context, cert Certificate) (acmeIssuerSpec, error) {
+	if cert.IssuerRefKind == "ClusterIssuer" {
+		var issuer cmapiv1alpha2.ClusterIssuer
+		if err := c.Client.Get(ctx, client.ObjectKey{Name: cert.IssuerRefName}, &issuer); err != nil {
+			return acmeIssuerSpec{}, fmt.Errorf("error fetching ClusterIssuer %q: %w", cert.IssuerRefName, err)
+		}
+		if issuer.Spec.ACME == nil {
+			return acmeIssuerSpec{}, fmt.Errorf("ClusterIssuer %q is not an ACME issuer", cert.IssuerRefName)
+		}
+		return acmeIssuerSpec{server: issuer.Spec.ACME.Server, solvers: v1alpha2ToAcmeSolvers(issuer.Spec.ACME.Solvers)}, nil
+	}
+
+	var issuer cmapiv1alpha2.Issuer
+	if err := c.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.IssuerRefName}, &issuer); err != nil {
+		return acmeIssuerSpec{}, fmt.Errorf("error fetching Issuer %q: %w", cert.IssuerRefName, err)
+	}
+	if issuer.Spec.ACME == nil {
+		return acmeIssuerSpec{}, fmt.Errorf("Issuer %q is not an ACME issuer", cert.IssuerRefName)
+	}
+	return acmeIssuerSpec{server: issuer.Spec.ACME.Server, solvers: v1alpha2ToAcmeSolvers(issuer.Spec.ACME.Solvers)}, nil
+}
+
+// v1alpha2ToAcmeSolvers converts the acme/v1alpha2 ACMEChallengeSolver list
+// (the same shape as every other certmanager.io API version, just a
+// distinct Go type per version) to the version-agnostic acmeSolver used
+// for selection.
+func v1alpha2ToAcmeSolvers(solvers []cmapiv1alpha2acme.ACMEChallengeSolver) []acmeSolver {
+	out := make([]acmeSolver, 0, len(solvers))
+	for _, s := range solvers {
+		solver := acmeSolver{dns01: s.DNS01 != nil, http01: s.HTTP01 != nil}
+		if s.Selector != nil {
+			solver.dnsNames = s.Selector.DNSNames
+			solver.dnsZones = s.Selector.DNSZones
+		}
+		out = append(out, solver)
+	}
+	return out
+}
+
+func (c *v1alpha2CertClient) NewCertificateObject() client.Object {
+	return &cmapiv1alpha2.Certificate{}
+}
+
+// v1CertClient talks to the current cert-manager.io/v1 API.
+type v1CertClient struct {
+	Client client.Client
+}
+
+func (c *v1CertClient) ListCertificates(ctx context.Context) ([]Certificate, error) {
+	var list cmapiv1.CertificateList
+	if err := c.Client.List(ctx, &list); err != nil {
+		return nil, fmt.Errorf("error listing v1 Certificate resources: %w", err)
+	}
+	out := make([]Certificate, 0, len(list.Items))
+	for _, crt := range list.Items {
+		out = append(out, Certificate{
+			Namespace:     crt.Namespace,
+			Name:          crt.Name,
+			UID:           crt.UID,
+			SecretName:    crt.Spec.SecretName,
+			IssuerRefName: crt.Spec.IssuerRef.Name,
+			IssuerRefKind: crt.Spec.IssuerRef.Kind,
+			DNSNames:      crt.Spec.DNSNames,
+			Annotations:   crt.Annotations,
+		})
+	}
+	return out, nil
+}
+
+func (c *v1CertClient) ListCertificateRequests(ctx context.Context, namespace string) ([]CertificateRequest, error) {
+	var list cmapiv1.CertificateRequestList
+	if err := c.Client.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing v1 CertificateRequest resources: %w", err)
+	}
+	out := make([]CertificateRequest, 0, len(list.Items))
+	for _, req := range list.Items {
+		out = append(out, CertificateRequest{
+			Namespace:    req.Namespace,
+			Name:         req.Name,
+			OwnerUID:     controllerOwnerUID(req.OwnerReferences),
+			IssuanceDone: len(req.Status.Certificate) > 0,
+		})
+	}
+	return out, nil
+}
+
+func (c *v1CertClient) DeleteCertificateRequest(ctx context.Context, namespace, name string) error {
+	req := &cmapiv1.CertificateRequest{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	return c.Client.Delete(ctx, req)
+}
+
+// TriggerRenewal sets the Certificate's status.conditions[type=Issuing]
+// condition to True via the /status subresource - the officially supported
+// mechanism used by `cmctl renew` against cert-manager.io/v1.
+func (c *v1CertClient) TriggerRenewal(ctx context.Context, cert Certificate) error {
+	var fresh cmapiv1.Certificate
+	if err := c.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.Name}, &fresh); err != nil {
+		return fmt.Errorf("error fetching Certificate before triggering renewal: %w", err)
+	}
+	cmutil.SetCertificateCondition(&fresh, fresh.Generation, cmapiv1.CertificateConditionIssuing, cmmeta.ConditionTrue, "ManuallyTriggered", "Renewal triggered by k8s-letsencrypt-force-renew")
+	if err := c.Client.Status().Update(ctx, &fresh); err != nil {
+		return fmt.Errorf("error setting Issuing condition on Certificate: %w", err)
+	}
+	return nil
+}
+
+func (c *v1CertClient) IssuerACMEServer(ctx context.Context, cert Certificate) (string, error) {
+	return issuerACMEServer(ctx, cert, c.acmeIssuerSpec)
+}
+
+func (c *v1CertClient) ResolveSolverKind(ctx context.Context, cert Certificate, dnsName string) (solverKind, error) {
+	return resolveSolverKind(ctx, cert, dnsName, c.acmeIssuerSpec)
+}
+
+func (c *v1CertClient) acmeIssuerSpec(ctx context.Context, cert Certificate) (acmeIssuerSpec, error) {
+	if cert.IssuerRefKind == "ClusterIssuer" {
+		var issuer cmapiv1.ClusterIssuer
+		if err := c.Client.Get(ctx, client.ObjectKey{Name: cert.IssuerRefName}, &issuer); err != nil {
+			return acmeIssuerSpec{}, fmt.Errorf("error fetching ClusterIssuer %q: %w", cert.IssuerRefName, err)
+		}
+		if issuer.Spec.ACME == nil {
+			return acmeIssuerSpec{}, fmt.Errorf("ClusterIssuer %q is not an ACME issuer", cert.IssuerRefName)
+		}
+		return acmeIssuerSpec{server: issuer.Spec.ACME.Server, solvers: v1ToAcmeSolvers(issuer.Spec.ACME.Solvers)}, nil
+	}
+
+	var issuer cmapiv1.Issuer
+	if err := c.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.IssuerRefName}, &issuer); err != nil {
+		return acmeIssuerSpec{}, fmt.Errorf("error fetching Issuer %q: %w", cert.IssuerRefName, err)
+	}
+	if issuer.Spec.ACME == nil {
+		return acmeIssuerSpec{}, fmt.Errorf("Issuer %q is not an ACME issuer", cert.IssuerRefName)
+	}
+	return acmeIssuerSpec{server: issuer.Spec.ACME.Server, solvers: v1ToAcmeSolvers(issuer.Spec.ACME.Solvers)}, nil
+}
+
+// v1ToAcmeSolvers converts the acme/v1 ACMEChallengeSolver list to the
+// version-agnostic acmeSolver used for selection.
+func v1ToAcmeSolvers(solvers []cmapiv1acme.ACMEChallengeSolver) []acmeSolver {
+	out := make([]acmeSolver, 0, len(solvers))
+	for _, s := range solvers {
+		solver := acmeSolver{dns01: s.DNS01 != nil, http01: s.HTTP01 != nil}
+		if s.Selector != nil {
+			solver.dnsNames = s.Selector.DNSNames
+			solver.dnsZones = s.Selector.DNSZones
+		}
+		out = append(out, solver)
+	}
+	return out
+}
+
+func (c *v1CertClient) NewCertificateObject() client.Object {
+	return &cmapiv1.Certificate{}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func solver(dnsNames, dnsZones []string, dns01, http01 bool) acmeSolver {
+	return acmeSolver{dnsNames: dnsNames, dnsZones: dnsZones, dns01: dns01, http01: http01}
+}
+
+func TestSelectSolverKind(t *testing.T) {
+	tests := []struct {
+		name    string
+		solvers []acmeSolver
+		dnsName string
+		want    solverKind
+	}{
+		{
+			name:    "no solvers matches nothing",
+			solvers: nil,
+			dnsName: "example.com",
+			want:    solverNone,
+		},
+		{
+			name: "exact dnsNames match wins over a zone match",
+			solvers: []acmeSolver{
+				solver(nil, []string{"example.com"}, true, false),
+				solver([]string{"www.example.com"}, nil, false, true),
+			},
+			dnsName: "www.example.com",
+			want:    solverHTTP01,
+		},
+		{
+			name: "most specific dnsZones match wins",
+			solvers: []acmeSolver{
+				solver(nil, []string{"example.com"}, true, false),
+				solver(nil, []string{"www.example.com"}, false, true),
+			},
+			dnsName: "www.example.com",
+			want:    solverHTTP01,
+		},
+		{
+			name: "zone match applies to subdomains",
+			solvers: []acmeSolver{
+				solver(nil, []string{"example.com"}, true, false),
+			},
+			dnsName: "foo.example.com",
+			want:    solverDNS01,
+		},
+		{
+			name: "selector-less solver is used as a fallback",
+			solvers: []acmeSolver{
+				solver([]string{"other.com"}, nil, true, false),
+				solver(nil, nil, false, true),
+			},
+			dnsName: "www.example.com",
+			want:    solverHTTP01,
+		},
+		{
+			name: "no match when only unrelated selectors are configured",
+			solvers: []acmeSolver{
+				solver([]string{"other.com"}, nil, true, false),
+			},
+			dnsName: "www.example.com",
+			want:    solverNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectSolverKind(tt.solvers, tt.dnsName); got != tt.want {
+				t.Errorf("selectSolverKind() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
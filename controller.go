@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	cmapiv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmapiv1alpha2 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// RefreshAnnotation, when set to "true" on a Certificate, requests that
+	// the CertificateRefreshReconciler trigger a renewal.
+	RefreshAnnotation = "k8s-letsencrypt-force-renew/refresh"
+	// RefreshStatusAnnotation reports the outcome of the most recent
+	// refresh requested via RefreshAnnotation.
+	RefreshStatusAnnotation = "k8s-letsencrypt-force-renew/refresh-status"
+
+	// RefreshStatusInProgress indicates a renewal has been triggered and
+	// the reconciler is waiting for it to complete.
+	RefreshStatusInProgress = "in-progress"
+	// RefreshStatusDone indicates the most recently requested renewal was
+	// handed to cert-manager: a CertificateRequest owned by the Certificate
+	// exists. Whether that CertificateRequest is then issued is not tracked.
+	RefreshStatusDone = "done"
+	// RefreshStatusFailed indicates the most recently requested renewal
+	// failed.
+	RefreshStatusFailed = "failed"
+
+	// LastRenewedAnnotation records, in RFC3339, when a refresh was most
+	// recently marked RefreshStatusDone. Selectors with no time dimension of
+	// their own (the legacy --issuerName filter, which keeps matching
+	// forever since cert-manager reissuance doesn't change the issuer
+	// name) use it to cool down between renewal requests instead of
+	// re-triggering on every --scanInterval.
+	LastRenewedAnnotation = "k8s-letsencrypt-force-renew/last-renewed"
+
+	eventReasonInProgress = "CertificatesRefreshInProgress"
+	eventReasonDone       = "CertificatesRefreshDone"
+	eventReasonFailed     = "CertificatesRefreshFailed"
+)
+
+// CertificateRefreshReconciler watches Certificate resources and, when asked
+// to via RefreshAnnotation, triggers a renewal using the same logic the
+// original one-shot CLI used. It is safe to run continuously: a Certificate
+// is only ever acted on once per refresh request, and the outcome is
+// recorded on RefreshStatusAnnotation so repeated reconciles are no-ops.
+type CertificateRefreshReconciler struct {
+	Client     client.Client
+	CertClient CertClient
+	Recorder   record.EventRecorder
+}
+
+// Reconcile implements reconcile.Reconciler.
+func (r *CertificateRefreshReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	obj := r.CertClient.NewCertificateObject()
+	if err := r.Client.Get(ctx, req.NamespacedName, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, fmt.Errorf("error fetching Certificate %s: %w", req.NamespacedName, err)
+	}
+
+	if obj.GetAnnotations()[RefreshAnnotation] != "true" {
+		return reconcile.Result{}, nil
+	}
+
+	// Already acting on this request (RefreshStatusInProgress), or already
+	// failed it (RefreshStatusFailed): either way, our own setStatus/
+	// clearRefresh Update calls below would otherwise immediately
+	// re-trigger this Reconcile via the Certificate watch, turning any
+	// persistent failure into a tight retry loop against the API server
+	// and the ACME server. Don't retry a failed request ourselves - the
+	// scanner re-arms RefreshAnnotation (at most once per --scanInterval)
+	// once it determines the Certificate is still due.
+	switch obj.GetAnnotations()[RefreshStatusAnnotation] {
+	case RefreshStatusInProgress, RefreshStatusFailed:
+		return reconcile.Result{}, nil
+	}
+
+	log.Printf("Refresh requested for Certificate %s/%s, triggering renewal", obj.GetNamespace(), obj.GetName())
+
+	if err := r.setStatus(ctx, obj, RefreshStatusInProgress); err != nil {
+		return reconcile.Result{}, err
+	}
+	r.Recorder.Eventf(obj, core.EventTypeNormal, eventReasonInProgress, "Renewal of Certificate triggered via %s annotation", RefreshAnnotation)
+
+	cert := Certificate{Namespace: obj.GetNamespace(), Name: obj.GetName(), UID: obj.GetUID()}
+	switch c := obj.(type) {
+	case *cmapiv1.Certificate:
+		cert.SecretName = c.Spec.SecretName
+	case *cmapiv1alpha2.Certificate:
+		cert.SecretName = c.Spec.SecretName
+	}
+	if err := renewCertificate(ctx, r.CertClient, cert); err != nil {
+		log.Printf("Failed to renew Certificate %s/%s: %v", cert.Namespace, cert.Name, err)
+		r.Recorder.Eventf(obj, core.EventTypeWarning, eventReasonFailed, "Renewal of Certificate failed: %v", err)
+		if statusErr := r.setStatus(ctx, obj, RefreshStatusFailed); statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		return reconcile.Result{}, err
+	}
+
+	r.Recorder.Event(obj, core.EventTypeNormal, eventReasonDone, "Renewal of Certificate completed")
+	if err := r.clearRefresh(ctx, obj); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// setStatus patches RefreshStatusAnnotation to the given value on a fresh
+// copy of the Certificate, to avoid clobbering concurrent status updates.
+func (r *CertificateRefreshReconciler) setStatus(ctx context.Context, obj client.Object, status string) error {
+	fresh := r.CertClient.NewCertificateObject()
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: obj.GetName()}, fresh); err != nil {
+		return fmt.Errorf("error fetching Certificate before status update: %w", err)
+	}
+	annotations := fresh.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[RefreshStatusAnnotation] = status
+	fresh.SetAnnotations(annotations)
+	if err := r.Client.Update(ctx, fresh); err != nil {
+		return fmt.Errorf("error updating Certificate %s annotation: %w", RefreshStatusAnnotation, err)
+	}
+	return nil
+}
+
+// clearRefresh removes RefreshAnnotation, records RefreshStatusDone, and
+// stamps LastRenewedAnnotation with the completion time, so the next
+// reconcile for this Certificate is a no-op until requested again and the
+// scanner can cool down selectors that have no time dimension of their own.
+func (r *CertificateRefreshReconciler) clearRefresh(ctx context.Context, obj client.Object) error {
+	fresh := r.CertClient.NewCertificateObject()
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: obj.GetName()}, fresh); err != nil {
+		return fmt.Errorf("error fetching Certificate before clearing refresh annotation: %w", err)
+	}
+	annotations := fresh.GetAnnotations()
+	delete(annotations, RefreshAnnotation)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[RefreshStatusAnnotation] = RefreshStatusDone
+	annotations[LastRenewedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	fresh.SetAnnotations(annotations)
+	if err := r.Client.Update(ctx, fresh); err != nil {
+		return fmt.Errorf("error clearing %s annotation: %w", RefreshAnnotation, err)
+	}
+	return nil
+}
+
+// SetupWithManager registers the reconciler with mgr, watching Certificates
+// directly and Secrets owned by a Certificate (cert-manager sets an owner
+// reference on the Secret it populates).
+func (r *CertificateRefreshReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("k8s-letsencrypt-force-renew")
+	return ctrl.NewControllerManagedBy(mgr).
+		For(r.CertClient.NewCertificateObject()).
+		Owns(&core.Secret{}).
+		Complete(r)
+}
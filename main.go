@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
@@ -9,25 +10,42 @@ import (
 	"time"
 
 	"github.com/jetstack/cert-manager/pkg/api"
-	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
 	"github.com/jetstack/cert-manager/pkg/util/pki"
 	core "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
 var (
-	issuerName string
-	renew      bool
+	issuerName              string
+	issuerNameCooldown      time.Duration
+	scanInterval            time.Duration
+	useARI                  bool
+	renewBefore             time.Duration
+	minRemainingPercent     float64
+	selMode                 selectorMode
+	output                  string
+	leaderElect             bool
+	leaderElectionNamespace string
 )
 
+// leaderElectionID identifies the Lease used to elect a single active
+// replica when running with leader election enabled.
+const leaderElectionID = "k8s-letsencrypt-force-renew-leader-election"
+
 func init() {
 	flag.StringVar(&issuerName, "issuerName", "", "Filter affected certs by issuer name")
-	flag.BoolVar(&renew, "renew", false, "If true, any affected certificates will be renewed. This may take a few minutes per Certificate.")
+	flag.DurationVar(&issuerNameCooldown, "issuerName-cooldown", 24*time.Hour, "Minimum time to wait after a successful renewal before --issuerName can select the same Certificate again - --issuerName has no notion of a renewal window of its own, so without a cooldown it would match every --scanInterval forever")
+	flag.DurationVar(&scanInterval, "scanInterval", time.Minute*5, "How often to scan Certificate resources for ones that need a refresh annotation set")
+	flag.BoolVar(&useARI, "useARI", true, "Consult the issuing ACME server's Renewal Information (ARI, RFC 9773) endpoint to decide when a certificate is due renewal, falling back to the existing issuerName-based selection when ARI isn't available")
+	flag.DurationVar(&renewBefore, "renew-before", 0, "Also select certificates with less than this long remaining until NotAfter (e.g. 720h). 0 disables this predicate")
+	flag.Float64Var(&minRemainingPercent, "min-remaining-percent", 0, "Also select certificates with less than this percentage of their total validity period remaining. 0 disables this predicate")
+	flag.Var(&selMode, "selector-mode", "How to combine the issuerName/ARI predicate with the renew-before/min-remaining-percent predicate: \"or\" (default) or \"and\"")
+	flag.StringVar(&output, "output", "", "Set to \"json\" to additionally print a JSON summary of selected certificates to stdout")
+	flag.BoolVar(&leaderElect, "leader-elect", true, "Enable leader election so only one replica of the Deployment is active at a time")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace holding the leader election Lease; defaults to the Pod's own namespace when running in-cluster")
 }
 
 func main() {
@@ -35,147 +53,313 @@ func main() {
 
 	if issuerName != "" {
 		log.Printf("!!!!! --issuerName has been set. Filter onyl certs with issues by: %s !!!!!", issuerName)
-
-	}
-	if renew {
-		log.Printf("!!!!! --renew has been set to TRUE. Any affected certificates will have a renewal automatically triggered if found !!!!!")
-		log.Printf("!!!!! Waiting 5s before proceeding, if you DO NOT renewals to be triggered, hit ctrl+c NOW !!!!!")
-		time.Sleep(time.Second * 5)
 	}
-	log.Println("This tool will query a Kubernetes cluster, check if any " +
-		"certificates are issued with cert-manager " +
-		"and trigger a renewal of any affected certificates. " +
-		"It is not safe to run multiple times, it will trigger a renewal every time.")
 
-	if err := run(); err != nil {
+	log.Println("Starting k8s-letsencrypt-force-renew controller. It will continuously watch " +
+		"Certificate resources, periodically scan them for ones that need renewing, and " +
+		"drive renewal of any Certificate annotated with " + RefreshAnnotation + "=true. " +
+		"It is safe to leave running: each refresh request is only actioned once.")
+
+	if err := runManager(); err != nil {
 		log.Printf("%v", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	ctx := context.Background()
-
-	// Build an API client
+// runManager builds a controller-runtime Manager, registers the
+// CertificateRefreshReconciler, starts the periodic scanner, and blocks
+// until the manager is stopped.
+func runManager() error {
 	cfg := ctrl.GetConfigOrDie()
-	mapper, err := apiutil.NewDynamicRESTMapper(cfg)
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:                  api.Scheme,
+		LeaderElection:          leaderElect,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("error building manager: %w", err)
 	}
-	cl, err := client.New(cfg, client.Options{
-		Scheme: api.Scheme,
-		Mapper: mapper,
-	})
+
+	cc, err := NewCertClient(cfg, mgr.GetClient())
 	if err != nil {
-		return fmt.Errorf("error building API client: %w", err)
+		return fmt.Errorf("error building cert-manager API client: %w", err)
 	}
 
-	var certs capi.CertificateList
-	if err := cl.List(ctx, &certs); err != nil {
+	reconciler := &CertificateRefreshReconciler{Client: mgr.GetClient(), CertClient: cc}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("error registering CertificateRefreshReconciler: %w", err)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			log.Printf("manager exited with error: %v", err)
+		}
+	}()
+
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		return fmt.Errorf("failed waiting for cache sync")
+	}
+
+	ari := newARIClient()
+	wait.Until(func() {
+		if err := scan(ctx, mgr.GetClient(), cc, ari); err != nil {
+			log.Printf("scan failed: %v", err)
+		}
+	}, scanInterval, ctx.Done())
+
+	return nil
+}
+
+// scan lists Certificate and Secret resources, decides which certificates
+// are due for a refresh, and sets RefreshAnnotation=true on them so the
+// CertificateRefreshReconciler picks the renewal up. A certificate is due
+// when isDueForRenewal says so: the ARI/--issuerName predicate (ARI's
+// suggested renewal window, or - when ARI isn't available for its issuer -
+// the legacy --issuerName filter) combined with the renew-before/
+// min-remaining-percent predicate according to --selector-mode. Due
+// certificates that fail pre-flight validation are only requested with
+// --force.
+func scan(ctx context.Context, cl client.Client, cc CertClient, ari *ariClient) error {
+	certs, err := cc.ListCertificates(ctx)
+	if err != nil {
 		return fmt.Errorf("error listing Certificate resources: %w", err)
 	}
 
-	log.Printf("Found %d Certificate resources to check", len(certs.Items))
+	log.Printf("Found %d Certificate resources to check", len(certs))
 	var secrets core.SecretList
 	if err := cl.List(ctx, &secrets); err != nil {
 		return fmt.Errorf("error listing Secret resources: %w", err)
 	}
 
 	secretsMap := makeSecretsMap(secrets.Items)
-	serialsToCertificates := make(map[string]capi.Certificate)
 
 	skipped := 0
-	for _, crt := range certs.Items {
-		skip := false
-		log.Printf("+++ Checking Secret resource for Certificate %s/%s", crt.Namespace, crt.Name)
-		secret, ok := secretsMap[crt.Namespace+"/"+crt.Spec.SecretName]
+	var due []Certificate
+	var candidates []candidate
+	for _, crt := range certs {
+		if crt.Annotations[RefreshAnnotation] == "true" && crt.Annotations[RefreshStatusAnnotation] != RefreshStatusFailed {
+			// Already requested and still in progress, waiting for the
+			// reconciler to act on it. A previously failed request is
+			// re-evaluated below instead: the reconciler deliberately
+			// won't retry RefreshStatusFailed itself (to avoid busy-looping
+			// on a persistent failure), so re-arming it is the scanner's
+			// job, at most once per --scanInterval.
+			continue
+		}
+
+		secret, ok := secretsMap[crt.Namespace+"/"+crt.SecretName]
 		if !ok {
-			log.Printf("Unable to find Secret resource %q, skipping...", crt.Spec.SecretName)
+			log.Printf("Unable to find Secret resource %q for Certificate %s/%s, skipping...", crt.SecretName, crt.Namespace, crt.Name)
 			skipped++
 			continue
 		}
 		if secret.Data == nil || secret.Data[core.TLSCertKey] == nil {
-			log.Printf("Secret %q does not contain any data for key %q, skipping...", crt.Spec.SecretName, core.TLSCertKey)
+			log.Printf("Secret %q does not contain any data for key %q, skipping...", crt.SecretName, core.TLSCertKey)
 			skipped++
 			continue
 		}
-		certPEM := secret.Data[core.TLSCertKey]
-		cert, err := pki.DecodeX509CertificateBytes(certPEM)
+		x509Cert, err := pki.DecodeX509CertificateBytes(secret.Data[core.TLSCertKey])
 		if err != nil {
-			log.Printf("Failed to decode x509 certificate data in Secret %q: %v, skipping...", crt.Spec.SecretName, err)
+			log.Printf("Failed to decode x509 certificate data in Secret %q: %v, skipping...", crt.SecretName, err)
 			skipped++
 			continue
 		}
 
-		//filter secrets by issuer name
-		if issuerName != "" {
-			for key, value := range secret.Annotations {
-				if key == "cert-manager.io/issuer-name" {
-					if value != issuerName {
-						skip = true
-						skipped++
-						continue
-					}
-				}
-			}
+		dueNow, reason, err := isDueForRenewal(ctx, cl, cc, ari, crt, secret, x509Cert)
+		if err != nil {
+			log.Printf("Error determining renewal eligibility for Certificate %s/%s: %v, skipping...", crt.Namespace, crt.Name, err)
+			skipped++
+			continue
+		}
+		if !dueNow {
+			skipped++
+			continue
+		}
+
+		due = append(due, crt)
+		candidates = append(candidates, candidate{
+			Namespace: crt.Namespace,
+			Name:      crt.Name,
+			Serial:    x509Cert.SerialNumber.String(),
+			Issuer:    crt.IssuerRefName,
+			NotBefore: x509Cert.NotBefore,
+			NotAfter:  x509Cert.NotAfter,
+			Remaining: time.Until(x509Cert.NotAfter).String(),
+			Reason:    reason,
+		})
+	}
+
+	candidatesByName := make(map[string]*candidate, len(candidates))
+	for i := range candidates {
+		c := &candidates[i]
+		candidatesByName[c.Namespace+"/"+c.Name] = c
+	}
+
+	log.Printf("%d Certificate(s) due a refresh, running DNS-01/HTTP-01 pre-flight checks before requesting one...", len(due))
+	affected := 0
+	for _, result := range preflightCheck(ctx, cc, due) {
+		c := candidatesByName[result.Certificate.Namespace+"/"+result.Certificate.Name]
+		c.PreflightOK = result.OK
+		c.PreflightReason = result.Reason
+
+		if !result.OK && !force {
+			log.Printf("Certificate %s/%s failed pre-flight validation (%s), skipping refresh request (use --force to override)", result.Certificate.Namespace, result.Certificate.Name, result.Reason)
+			continue
 		}
-		if !skip {
-			serialsToCertificates[cert.SerialNumber.String()] = crt
+
+		log.Printf("Certificate %s/%s is due a refresh (%s), requesting one via %s", result.Certificate.Namespace, result.Certificate.Name, c.Reason, RefreshAnnotation)
+		if err := requestRefresh(ctx, cl, cc, result.Certificate); err != nil {
+			log.Printf("Failed to request refresh of Certificate %s/%s: %v", result.Certificate.Namespace, result.Certificate.Name, err)
+			continue
 		}
+		c.Selected = true
+		affected++
 	}
 
 	log.Println("Finished analyzing certificates, results:")
 	log.Printf("  Skipped/unable to check: %d", skipped)
-	log.Printf("  Affected certificates: %d", len(serialsToCertificates))
+	log.Printf("  Refresh requested: %d", affected)
 
-	if len(serialsToCertificates) == 0 {
-		return nil
+	if output == "json" {
+		if err := printCandidatesJSON(candidates); err != nil {
+			log.Printf("Failed to print JSON summary: %v", err)
+		}
 	}
-	if !renew {
-		log.Println()
-		log.Printf("Will NOT trigger a renewal as --renew set to false")
-		return nil
+	return nil
+}
+
+// isDueForRenewal decides whether crt should be renewed, composing the
+// issuerName/ARI predicate with the renew-before/min-remaining-percent
+// predicate according to --selector-mode.
+func isDueForRenewal(ctx context.Context, cl client.Client, cc CertClient, ari *ariClient, crt Certificate, secret core.Secret, cert *x509.Certificate) (due bool, reason string, err error) {
+	issuerDue, issuerReason, err := issuerDueForRenewal(ctx, cl, cc, ari, crt, secret, cert)
+	if err != nil {
+		return false, "", err
 	}
+	expDue, expReason := expirationDue(cert, renewBefore, minRemainingPercent)
 
-	log.Println()
-	log.Printf("Will now attempting to renew the following certificates:")
-	for sn, cert := range serialsToCertificates {
-		log.Printf("  * %s/%s (serial number: %s)", cert.Namespace, cert.Name, sn)
+	if selMode == selectorModeAnd {
+		if issuerDue && expDue {
+			return true, issuerReason + "; " + expReason, nil
+		}
+		return false, "", nil
 	}
-	log.Println()
-	log.Printf("!!!!! Will now attempt to renew %d certificates, waiting 2s... !!!!!", len(serialsToCertificates))
-	time.Sleep(time.Second * 2)
-	log.Println()
 
-	for _, cert := range serialsToCertificates {
-		log.Printf("Triggering renewal of Certificate %s/%s", cert.Namespace, cert.Name)
-		if err := renewCertificate(ctx, cl, cert); err != nil {
-			log.Printf("Failed to renew certificate %s/%s: %v", cert.Namespace, cert.Name, err)
-			return err
+	// Default: "or"
+	switch {
+	case issuerDue:
+		return true, issuerReason, nil
+	case expDue:
+		return true, expReason, nil
+	default:
+		return false, "", nil
+	}
+}
+
+// issuerDueForRenewal decides whether crt should be renewed based on ARI,
+// falling back to the legacy --issuerName filter when its issuer's ACME
+// directory doesn't support ARI. Neither predicate is allowed to fail
+// open: if ARI can't be consulted (or reports no renewalInfo) and
+// --issuerName wasn't set, crt is left alone rather than treated as a
+// match - an unset filter means "nothing selected", not "everything
+// selected". Unlike ARI (which recomputes a fresh suggested window off
+// the reissued certificate) or the renew-before/min-remaining-percent
+// predicates (which recheck against the reissued certificate's NotAfter),
+// --issuerName has no time dimension at all - cert-manager reissuance
+// doesn't change the issuer-name annotation, so it keeps matching forever
+// unless explicitly cooled down against --issuerName-cooldown.
+func issuerDueForRenewal(ctx context.Context, cl client.Client, cc CertClient, ari *ariClient, crt Certificate, secret core.Secret, cert *x509.Certificate) (due bool, reason string, err error) {
+	if useARI {
+		issuerURL, err := cc.IssuerACMEServer(ctx, crt)
+		if err != nil {
+			log.Printf("Unable to resolve ACME directory for Certificate %s/%s: %v, falling back to --issuerName filter", crt.Namespace, crt.Name, err)
+		} else {
+			due, ok, err := ariDueForRenewal(ctx, cl, ari, crt, secret, issuerURL, cert)
+			if err != nil {
+				return false, "", err
+			}
+			if ok {
+				return due, "ARI suggested window reached", nil
+			}
+			// Issuer's directory has no renewalInfo - fall through to
+			// the legacy filter below.
 		}
 	}
-	return nil
+
+	if issuerName == "" {
+		// No explicit selector configured and ARI couldn't make a
+		// determination - fail closed rather than selecting every
+		// Certificate in the cluster.
+		return false, "", nil
+	}
+	if secret.Annotations["cert-manager.io/issuer-name"] != issuerName {
+		return false, "", nil
+	}
+	if since, ok := lastRenewed(crt); ok && since < issuerNameCooldown {
+		return false, "", nil
+	}
+	return true, "matched --issuerName filter", nil
+}
+
+// lastRenewed returns how long ago crt's last successful renewal
+// completed, reading LastRenewedAnnotation. ok is false if crt has never
+// been renewed by this tool.
+func lastRenewed(crt Certificate) (since time.Duration, ok bool) {
+	v, present := crt.Annotations[LastRenewedAnnotation]
+	if !present {
+		return 0, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(t), true
 }
 
-func renewCertificate(ctx context.Context, cl client.Client, cert capi.Certificate) error {
-	var requests capi.CertificateRequestList
-	if err := cl.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
+// requestRefresh sets RefreshAnnotation=true on a fresh copy of crt,
+// clearing any RefreshStatusFailed left over from a previous request so
+// the reconciler doesn't mistake this new request for the old one it
+// deliberately won't retry on its own.
+func requestRefresh(ctx context.Context, cl client.Client, cc CertClient, crt Certificate) error {
+	fresh := cc.NewCertificateObject()
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: crt.Name}, fresh); err != nil {
 		return err
 	}
-	for _, req := range requests.Items {
+	annotations := fresh.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[RefreshAnnotation] = "true"
+	delete(annotations, RefreshStatusAnnotation)
+	fresh.SetAnnotations(annotations)
+	return cl.Update(ctx, fresh)
+}
+
+// renewCertificate clears out any completed CertificateRequest left over
+// from a previous issuance and asks cert-manager to issue a new one for
+// cert, then waits for the resulting CertificateRequest to appear.
+func renewCertificate(ctx context.Context, cc CertClient, cert Certificate) error {
+	requests, err := cc.ListCertificateRequests(ctx, cert.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, req := range requests {
 		// If any existing CertificateRequest resources exist and are complete,
 		// we delete them to avoid a re-issuance of the same certificate.
-		if !metav1.IsControlledBy(&req, &cert) {
+		if req.OwnerUID != cert.UID {
 			continue
 		}
 
 		// This indicates an issuance is currently in progress
-		if len(req.Status.Certificate) == 0 {
+		if !req.IssuanceDone {
 			log.Printf("Found existing CertificateRequest %s/%s for Certificate - skipping triggering a renewal...", req.Namespace, req.Name)
 			return nil
 		}
 
-		if err := cl.Delete(ctx, &req); err != nil {
+		if err := cc.DeleteCertificateRequest(ctx, req.Namespace, req.Name); err != nil {
 			log.Printf("Failed to delete old CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
 			return err
 		}
@@ -183,35 +367,21 @@ func renewCertificate(ctx context.Context, cl client.Client, cert capi.Certifica
 		log.Printf("Deleted old CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
 	}
 
-	// Fetch an up to date copy of the Secret resource for this Certificate
-	var secret core.Secret
-	if err := cl.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.Spec.SecretName}, &secret); err != nil {
-		log.Printf("Failed to retrieve up-to-date copy of existing Secret resource for Certificate: %v", err)
-		return err
-	}
-
-	// Manually override/set the IssuerNameAnnotationKey - this will cause cert-manager
-	// to assume that we have changed the 'issuerRef' specified on the Certificate and
-	// trigger a one-time renewal.
-	if secret.Annotations == nil {
-		secret.Annotations = make(map[string]string)
-	}
-	secret.Annotations[capi.IssuerNameAnnotationKey] = "force-renewal-triggered"
-	if err := cl.Update(ctx, &secret); err != nil {
-		log.Printf("Failed to update Secret resource for Certificate: %v", err)
+	if err := cc.TriggerRenewal(ctx, cert); err != nil {
+		log.Printf("Failed to trigger renewal of Certificate: %v", err)
 		return err
 	}
 
 	log.Printf("Triggered renewal of Certificate - waiting for new CertificateRequest resource to be created...")
 	// Wait for a CertificateRequest resource to be created
-	err := wait.Poll(time.Second, time.Minute, func() (bool, error) {
-		var requests capi.CertificateRequestList
-		if err := cl.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
+	err = wait.Poll(time.Second, time.Minute, func() (bool, error) {
+		requests, err := cc.ListCertificateRequests(ctx, cert.Namespace)
+		if err != nil {
 			return false, err
 		}
 		// Wait for a CertificateRequest owned by this Certificate to exist
-		for _, req := range requests.Items {
-			if metav1.IsControlledBy(&req, &cert) {
+		for _, req := range requests {
+			if req.OwnerUID == cert.UID {
 				log.Printf("CertificateRequest %s/%s found, renewal in progress!", req.Namespace, req.Name)
 				return true, nil
 			}
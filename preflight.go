@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	force                bool
+	preflightParallelism int
+	preflightTimeout     time.Duration
+)
+
+func init() {
+	flag.BoolVar(&force, "force", false, "Renew Certificates even if DNS-01/HTTP-01 pre-flight validation reports their ACME challenge as unsolvable")
+	flag.IntVar(&preflightParallelism, "preflight-parallelism", 10, "How many Certificates to run pre-flight validation against concurrently")
+	flag.DurationVar(&preflightTimeout, "preflight-timeout", 10*time.Second, "Timeout for each individual DNS/HTTP pre-flight check")
+}
+
+// preflightResult reports whether a Certificate's ACME challenges look
+// solvable right now, and why not if they don't.
+type preflightResult struct {
+	Certificate Certificate
+	OK          bool
+	Reason      string
+}
+
+// preflightCheck validates, for every DNS name on a Certificate, that its
+// ACME challenge is solvable right now: dns01 names are checked against
+// their zone's CAA records and, where present, the reachability of a
+// delegated _acme-challenge CNAME; http01 names are checked by fetching the
+// well-known challenge path and confirming some HTTP server answers it. It
+// exists to stop a bulk renewal run from burning through ACME
+// rate limits on domains whose DNS/ingress is currently broken.
+func preflightCheck(ctx context.Context, cc CertClient, certs []Certificate) []preflightResult {
+	results := make([]preflightResult, len(certs))
+
+	sem := make(chan struct{}, preflightParallelism)
+	var wg sync.WaitGroup
+	for i, crt := range certs {
+		wg.Add(1)
+		go func(i int, crt Certificate) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			cctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+			defer cancel()
+			ok, reason := preflightCertificate(cctx, cc, crt)
+			results[i] = preflightResult{Certificate: crt, OK: ok, Reason: reason}
+		}(i, crt)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// preflightCertificate checks every DNS name on crt, failing pre-flight if
+// any single one of them looks unsolvable.
+func preflightCertificate(ctx context.Context, cc CertClient, crt Certificate) (bool, string) {
+	for _, name := range crt.DNSNames {
+		kind, err := cc.ResolveSolverKind(ctx, crt, name)
+		if err != nil {
+			return false, fmt.Sprintf("unable to resolve ACME solver for %q: %v", name, err)
+		}
+
+		switch kind {
+		case solverDNS01:
+			if ok, reason := preflightDNS01(ctx, cc, crt, name); !ok {
+				return false, reason
+			}
+		case solverHTTP01:
+			if ok, reason := preflightHTTP01(ctx, name); !ok {
+				return false, reason
+			}
+		default:
+			// No solver matched this name - nothing we can validate
+			// in advance, so don't block the renewal on it.
+		}
+	}
+	return true, ""
+}
+
+// preflightDNS01 confirms the zone covering name permits cert's ACME
+// issuer to issue via CAA, and that a delegated _acme-challenge CNAME (if
+// any) resolves, querying the zone's own authoritative nameservers rather
+// than relying on a potentially stale recursive resolver cache.
+func preflightDNS01(ctx context.Context, cc CertClient, crt Certificate, name string) (bool, string) {
+	issuerURL, err := cc.IssuerACMEServer(ctx, crt)
+	if err != nil {
+		return false, fmt.Sprintf("unable to resolve ACME server for issuer: %v", err)
+	}
+	caaIdentity := caaIdentityForACMEServer(issuerURL)
+
+	zone, nameservers, err := authoritativeNameservers(ctx, name)
+	if err != nil {
+		return false, fmt.Sprintf("unable to resolve authoritative nameservers for %q: %v", name, err)
+	}
+
+	caaRecords, err := queryCAA(ctx, nameservers, zone)
+	if err != nil {
+		return false, fmt.Sprintf("unable to query CAA records for zone %q: %v", zone, err)
+	}
+	if ok, reason := caaPermitsIssuer(caaRecords, caaIdentity); !ok {
+		return false, reason
+	}
+
+	if ok, reason := acmeChallengeCNAMEReachable(ctx, nameservers, name); !ok {
+		return false, reason
+	}
+
+	return true, ""
+}
+
+// preflightHTTP01 does an unauthenticated GET of the ACME HTTP01
+// well-known path for name and passes on any HTTP response. It catches an
+// ingress path that is unreachable or times out; it does not tell a solver
+// Service apart from a default backend or any other HTTP server.
+func preflightHTTP01(ctx context.Context, name string) (bool, string) {
+	target := url.URL{Scheme: "http", Host: name, Path: "/.well-known/acme-challenge/ping"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	httpClient := &http.Client{
+		Timeout: preflightTimeout,
+		// We only care whether the ingress path reaches *some* HTTP
+		// server - don't follow it off to an unrelated host.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Sprintf("HTTP-01 ingress path for %q is unreachable: %v", name, err)
+	}
+	resp.Body.Close()
+	// The well-known path itself 404s outside of an in-progress
+	// challenge, so any HTTP response at all is accepted: it confirms
+	// the name resolves and an HTTP server answers, not which one.
+	return true, ""
+}
+
+// authoritativeNameservers walks up from name's parent zone looking for the
+// first one with NS records, returning that zone and its nameservers.
+func authoritativeNameservers(ctx context.Context, name string) (zone string, nameservers []string, err error) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := 1; i < len(labels); i++ {
+		candidate := strings.Join(labels[i:], ".")
+		nsRecords, lookupErr := net.DefaultResolver.LookupNS(ctx, candidate)
+		if lookupErr != nil || len(nsRecords) == 0 {
+			continue
+		}
+		ns := make([]string, 0, len(nsRecords))
+		for _, n := range nsRecords {
+			ns = append(ns, strings.TrimSuffix(n.Host, "."))
+		}
+		return candidate, ns, nil
+	}
+	return "", nil, fmt.Errorf("no parent zone of %q has any NS records", name)
+}
+
+// queryCAA fetches the CAA record set for zone directly from one of
+// nameservers.
+func queryCAA(ctx context.Context, nameservers []string, zone string) ([]*dns.CAA, error) {
+	c := &dns.Client{Timeout: preflightTimeout}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone), dns.TypeCAA)
+
+	var lastErr error
+	for _, ns := range nameservers {
+		resp, _, err := c.ExchangeContext(ctx, m, net.JoinHostPort(ns, "53"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var records []*dns.CAA
+		for _, rr := range resp.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("all nameservers for %q failed: %w", zone, lastErr)
+}
+
+// caaPermitsIssuer reports whether records allow caaIdentity to issue,
+// per RFC 8659: no "issue"/"issuewild" property at all (whether because
+// there are no CAA records, or only unrelated ones like iodef) means any
+// CA may issue. A CAA value's issuer domain name is only the part before
+// the first ";" - everything after it is optional parameters (e.g.
+// "letsencrypt.org; validationmethods=dns-01"), which must be ignored
+// rather than compared as part of the identity.
+func caaPermitsIssuer(records []*dns.CAA, caaIdentity string) (bool, string) {
+	sawIssueProperty := false
+	for _, r := range records {
+		if r.Tag != "issue" && r.Tag != "issuewild" {
+			continue
+		}
+		sawIssueProperty = true
+		issuerDomain := strings.TrimSpace(strings.SplitN(r.Value, ";", 2)[0])
+		if strings.EqualFold(issuerDomain, caaIdentity) {
+			return true, ""
+		}
+	}
+	if !sawIssueProperty {
+		return true, ""
+	}
+	return false, fmt.Sprintf("CAA records do not permit issuer %q", caaIdentity)
+}
+
+// acmeChallengeCNAMEReachable checks whether _acme-challenge.<name> is
+// delegated via a CNAME, and if so that the delegation target resolves.
+// Not every dns01 setup delegates the challenge record, so the absence of
+// a CNAME is not itself a failure.
+func acmeChallengeCNAMEReachable(ctx context.Context, nameservers []string, name string) (bool, string) {
+	challengeName := "_acme-challenge." + name
+
+	c := &dns.Client{Timeout: preflightTimeout}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(challengeName), dns.TypeCNAME)
+
+	var lastErr error
+	for _, ns := range nameservers {
+		resp, _, err := c.ExchangeContext(ctx, m, net.JoinHostPort(ns, "53"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range resp.Answer {
+			cname, ok := rr.(*dns.CNAME)
+			if !ok {
+				continue
+			}
+			target := strings.TrimSuffix(cname.Target, ".")
+			if _, err := net.DefaultResolver.LookupHost(ctx, target); err != nil {
+				return false, fmt.Sprintf("delegated _acme-challenge CNAME %q for %q does not resolve: %v", target, name, err)
+			}
+			return true, ""
+		}
+		return true, "" // No CNAME - not delegated, nothing more to check.
+	}
+	return false, fmt.Sprintf("unable to query any authoritative nameserver for %q: %v", challengeName, lastErr)
+}
+
+// caaIdentityForACMEServer returns the registrable domain of the ACME
+// server's hostname (e.g. "letsencrypt.org" for
+// acme-v02.api.letsencrypt.org), which is the conventional CAA "issue"
+// value for that CA. This is a best-effort heuristic, not a full public
+// suffix list lookup.
+func caaIdentityForACMEServer(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return serverURL
+	}
+	labels := strings.Split(u.Hostname(), ".")
+	if len(labels) < 2 {
+		return u.Hostname()
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
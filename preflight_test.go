@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func caaRecord(tag, value string) *dns.CAA {
+	return &dns.CAA{Tag: tag, Value: value}
+}
+
+func TestCAAPermitsIssuer(t *testing.T) {
+	tests := []struct {
+		name        string
+		records     []*dns.CAA
+		caaIdentity string
+		want        bool
+	}{
+		{
+			name:        "no records permits any issuer",
+			records:     nil,
+			caaIdentity: "letsencrypt.org",
+			want:        true,
+		},
+		{
+			name:        "exact match",
+			records:     []*dns.CAA{caaRecord("issue", "letsencrypt.org")},
+			caaIdentity: "letsencrypt.org",
+			want:        true,
+		},
+		{
+			name:        "issuewild tag also matches",
+			records:     []*dns.CAA{caaRecord("issuewild", "letsencrypt.org")},
+			caaIdentity: "letsencrypt.org",
+			want:        true,
+		},
+		{
+			name:        "value with parameters still matches the issuer domain",
+			records:     []*dns.CAA{caaRecord("issue", "letsencrypt.org; validationmethods=dns-01")},
+			caaIdentity: "letsencrypt.org",
+			want:        true,
+		},
+		{
+			name:        "unrelated issue tag blocks a different issuer",
+			records:     []*dns.CAA{caaRecord("issue", "digicert.com")},
+			caaIdentity: "letsencrypt.org",
+			want:        false,
+		},
+		{
+			name:        "iodef tag is ignored",
+			records:     []*dns.CAA{caaRecord("iodef", "mailto:admin@example.com")},
+			caaIdentity: "letsencrypt.org",
+			want:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := caaPermitsIssuer(tt.records, tt.caaIdentity)
+			if got != tt.want {
+				t.Errorf("caaPermitsIssuer() = %v (%q), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// selectorMode controls how the --renew-before/--min-remaining-percent
+// expiration predicate is combined with the issuerName/ARI predicate.
+type selectorMode string
+
+const (
+	// selectorModeOr renews a certificate if either predicate matches.
+	selectorModeOr selectorMode = "or"
+	// selectorModeAnd renews a certificate only if both predicates match.
+	selectorModeAnd selectorMode = "and"
+)
+
+func (m *selectorMode) String() string {
+	if *m == "" {
+		return string(selectorModeOr)
+	}
+	return string(*m)
+}
+
+func (m *selectorMode) Set(v string) error {
+	switch selectorMode(v) {
+	case selectorModeOr, selectorModeAnd:
+		*m = selectorMode(v)
+		return nil
+	default:
+		return fmt.Errorf("invalid --selector-mode %q: must be %q or %q", v, selectorModeOr, selectorModeAnd)
+	}
+}
+
+// candidate describes why a Certificate was (or wasn't) selected for
+// renewal, and is what --output=json reports.
+type candidate struct {
+	Namespace       string    `json:"namespace"`
+	Name            string    `json:"name"`
+	Serial          string    `json:"serial"`
+	Issuer          string    `json:"issuer"`
+	NotBefore       time.Time `json:"notBefore"`
+	NotAfter        time.Time `json:"notAfter"`
+	Remaining       string    `json:"remaining"`
+	Selected        bool      `json:"selected"`
+	Reason          string    `json:"reason,omitempty"`
+	PreflightOK     bool      `json:"preflightOK"`
+	PreflightReason string    `json:"preflightReason,omitempty"`
+}
+
+// expirationDue reports whether cert has fewer than renewBefore left until
+// expiry, or has less than minRemainingPercent of its total validity
+// remaining - whichever predicate is configured. A zero value for either
+// flag disables that half of the check.
+func expirationDue(cert *x509.Certificate, renewBefore time.Duration, minRemainingPercent float64) (bool, string) {
+	remaining := time.Until(cert.NotAfter)
+
+	if renewBefore > 0 && remaining < renewBefore {
+		return true, fmt.Sprintf("less than %s remaining until expiry", renewBefore)
+	}
+
+	if minRemainingPercent > 0 {
+		total := cert.NotAfter.Sub(cert.NotBefore)
+		if total > 0 {
+			remainingPercent := float64(remaining) / float64(total) * 100
+			if remainingPercent < minRemainingPercent {
+				return true, fmt.Sprintf("only %.1f%% of validity period remaining", remainingPercent)
+			}
+		}
+	}
+
+	return false, ""
+}
+
+// printCandidatesJSON writes candidates to stdout as a JSON array, for
+// piping into monitoring or GitOps pipelines.
+func printCandidatesJSON(candidates []candidate) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(candidates)
+}
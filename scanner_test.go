@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestExpirationDue(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name                string
+		notBefore           time.Time
+		notAfter            time.Time
+		renewBefore         time.Duration
+		minRemainingPercent float64
+		wantDue             bool
+	}{
+		{
+			name:        "both predicates disabled never triggers",
+			notBefore:   now.Add(-89 * 24 * time.Hour),
+			notAfter:    now.Add(time.Hour),
+			renewBefore: 0,
+			wantDue:     false,
+		},
+		{
+			name:        "less than renewBefore remaining is due",
+			notBefore:   now.Add(-89 * 24 * time.Hour),
+			notAfter:    now.Add(12 * time.Hour),
+			renewBefore: 24 * time.Hour,
+			wantDue:     true,
+		},
+		{
+			name:        "more than renewBefore remaining is not due",
+			notBefore:   now.Add(-89 * 24 * time.Hour),
+			notAfter:    now.Add(48 * time.Hour),
+			renewBefore: 24 * time.Hour,
+			wantDue:     false,
+		},
+		{
+			name:                "less than minRemainingPercent is due",
+			notBefore:           now.Add(-90 * 24 * time.Hour),
+			notAfter:            now.Add(9 * 24 * time.Hour),
+			minRemainingPercent: 20,
+			wantDue:             true,
+		},
+		{
+			name:                "more than minRemainingPercent is not due",
+			notBefore:           now.Add(-90 * 24 * time.Hour),
+			notAfter:            now.Add(30 * 24 * time.Hour),
+			minRemainingPercent: 20,
+			wantDue:             false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{NotBefore: tt.notBefore, NotAfter: tt.notAfter}
+			due, reason := expirationDue(cert, tt.renewBefore, tt.minRemainingPercent)
+			if due != tt.wantDue {
+				t.Errorf("expirationDue() = %v (%q), want %v", due, reason, tt.wantDue)
+			}
+			if due && reason == "" {
+				t.Error("expirationDue() returned due=true with no reason")
+			}
+		})
+	}
+}